@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hjson "github.com/hjson/hjson-go/v4"
+	"golang.org/x/text/encoding/unicode"
+	"gopkg.in/yaml.v3"
+)
+
+// RootConfig is the on-disk representation of a full cortex-bridge
+// deployment: a gateway instance plus, optionally, a config server. A
+// single HJSON or YAML file can bring up both subsystems, which is what
+// -conf/-useconf/-genconf/-normaliseconf operate on.
+type RootConfig struct {
+	Gateway      *GatewayConfig      `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	ConfigServer *ConfigServerConfig `json:"config_server,omitempty" yaml:"config_server,omitempty"`
+}
+
+// ConfigServerConfig is the file representation of a ConfigServerService:
+// db URL, geoip path, protocol, port, and an optional TLS cert/key pair.
+// When TLSCertFile/TLSKeyFile are set, the config server requires client
+// certificates and derives each device's DeviceID from its cert, instead
+// of trusting the machine_id/user_token pair reported in its heartbeat.
+type ConfigServerConfig struct {
+	DBURL                 string `json:"db_url" yaml:"db_url"`
+	GeoIPPath             string `json:"geoip_path,omitempty" yaml:"geoip_path,omitempty"`
+	Protocol              string `json:"protocol" yaml:"protocol"`
+	Port                  uint16 `json:"port" yaml:"port"`
+	TLSCertFile           string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile            string `json:"tls_key_file,omitempty" yaml:"tls_key_file,omitempty"`
+	NackRollbackThreshold int    `json:"nack_rollback_threshold,omitempty" yaml:"nack_rollback_threshold,omitempty"`
+}
+
+// DefaultGatewayConfig returns a GatewayConfig with the same defaults the
+// gateway example previously hard-coded, used both as the -genconf output
+// and to fill in fields a config file omits.
+func DefaultGatewayConfig() *GatewayConfig {
+	return &GatewayConfig{
+		InstanceName:            "cortex-server-gateway",
+		NetworkName:             "cortex-vpn",
+		RPCPort:                 15888,
+		DefaultProtocol:         "tcp",
+		EnableEncryption:        true,
+		EnableIPv6:              true,
+		MTU:                     1380,
+		ForeignNetworkWhitelist: "*",
+		NetstackMode:            NetstackKernelTUN,
+	}
+}
+
+// DefaultConfigServerConfig returns a ConfigServerConfig with sane
+// defaults for any field a config file omits.
+func DefaultConfigServerConfig() *ConfigServerConfig {
+	return &ConfigServerConfig{
+		Protocol:              "tcp",
+		Port:                  11020,
+		NackRollbackThreshold: defaultNackRollbackThreshold,
+	}
+}
+
+// applyGatewayDefaults fills in zero-valued fields from DefaultGatewayConfig.
+func applyGatewayDefaults(cfg *GatewayConfig) {
+	d := DefaultGatewayConfig()
+	if cfg.InstanceName == "" {
+		cfg.InstanceName = d.InstanceName
+	}
+	if cfg.NetworkName == "" {
+		cfg.NetworkName = d.NetworkName
+	}
+	if cfg.RPCPort == 0 {
+		cfg.RPCPort = d.RPCPort
+	}
+	if cfg.DefaultProtocol == "" {
+		cfg.DefaultProtocol = d.DefaultProtocol
+	}
+	if cfg.MTU == 0 {
+		cfg.MTU = d.MTU
+	}
+	if cfg.ForeignNetworkWhitelist == "" {
+		cfg.ForeignNetworkWhitelist = d.ForeignNetworkWhitelist
+	}
+	if cfg.NetstackMode == "" {
+		// Config files written before NetstackMode existed only set the
+		// legacy booleans; derive the equivalent mode from them so they
+		// keep behaving the same way.
+		switch {
+		case cfg.UseSmoltcp:
+			cfg.NetstackMode = NetstackSmoltcp
+		case cfg.NoTun:
+			cfg.NetstackMode = NetstackNone
+		default:
+			cfg.NetstackMode = NetstackKernelTUN
+		}
+	}
+}
+
+// applyConfigServerDefaults fills in zero-valued fields from
+// DefaultConfigServerConfig.
+func applyConfigServerDefaults(cfg *ConfigServerConfig) {
+	d := DefaultConfigServerConfig()
+	if cfg.Protocol == "" {
+		cfg.Protocol = d.Protocol
+	}
+	if cfg.Port == 0 {
+		cfg.Port = d.Port
+	}
+	if cfg.NackRollbackThreshold == 0 {
+		cfg.NackRollbackThreshold = d.NackRollbackThreshold
+	}
+}
+
+// Validate rejects mutually-exclusive options and missing required
+// fields before a RootConfig is used to bring anything up.
+func (r *RootConfig) Validate() error {
+	if r.Gateway != nil {
+		g := r.Gateway
+		if g.DHCP && g.IPv4 != "" {
+			return fmt.Errorf("gateway: dhcp and ipv4 are mutually exclusive")
+		}
+		if g.NoTun && g.DevName != "" {
+			return fmt.Errorf("gateway: no_tun and dev_name are mutually exclusive")
+		}
+		switch g.NetstackMode {
+		case "", NetstackKernelTUN, NetstackSmoltcp, NetstackGVisor, NetstackNone:
+		default:
+			return fmt.Errorf("gateway: unknown netstack_mode %q", g.NetstackMode)
+		}
+	}
+	if r.ConfigServer != nil && r.ConfigServer.DBURL == "" {
+		return fmt.Errorf("config_server: db_url is required")
+	}
+	return nil
+}
+
+// decodeUTF8 strips a leading UTF-8 BOM, or transcodes UTF-16 (LE or BE,
+// detected from its BOM) to UTF-8 and strips that. Windows editors
+// routinely save HJSON/YAML files in one of these encodings, which
+// otherwise either breaks parsing outright (UTF-16, whose every other
+// byte is 0x00) or leaves a stray marker byte (UTF-8 BOM) at the start
+// of the first token.
+func decodeUTF8(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:], nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+	default:
+		return data, nil
+	}
+}
+
+// configFormat picks HJSON or YAML based on a file's extension, defaulting
+// to HJSON (which also parses plain JSON, a subset of it).
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "hjson"
+	}
+}
+
+// decodeRootConfig unmarshals BOM-stripped, UTF-8 HJSON or YAML bytes into
+// a RootConfig. Each section present in data is decoded onto a struct
+// pre-populated with its Default*Config(), so the unmarshal only
+// overwrites keys actually present in the file — decoding onto a zero
+// GatewayConfig and defaulting afterward can't tell "omitted" from "set to
+// false", and would silently turn EnableEncryption/EnableIPv6 off for any
+// config file that simply doesn't mention them.
+func decodeRootConfig(data []byte, format string) (*RootConfig, error) {
+	data, err := decodeUTF8(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode config encoding: %w", err)
+	}
+
+	// First pass, with no defaults, only to see which top-level sections
+	// are present at all.
+	var presence RootConfig
+	if err := unmarshalRootConfig(data, format, &presence); err != nil {
+		return nil, err
+	}
+
+	cfg := &RootConfig{}
+	if presence.Gateway != nil {
+		cfg.Gateway = DefaultGatewayConfig()
+		// Leave NetstackMode unset so that, when the file omits it,
+		// applyGatewayDefaults derives it from the legacy booleans.
+		cfg.Gateway.NetstackMode = ""
+	}
+	if presence.ConfigServer != nil {
+		cfg.ConfigServer = DefaultConfigServerConfig()
+	}
+	if err := unmarshalRootConfig(data, format, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// unmarshalRootConfig decodes data onto cfg, reusing whatever Gateway/
+// ConfigServer pointers cfg already holds rather than replacing them, the
+// same way encoding/json and yaml.v3 merge onto an already non-nil
+// pointer field instead of allocating a fresh zero value for it.
+func unmarshalRootConfig(data []byte, format string, cfg *RootConfig) error {
+	switch format {
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("parse yaml: %w", err)
+		}
+	default:
+		if err := hjson.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("parse hjson: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseRootConfig decodes, defaults, and validates a RootConfig.
+func parseRootConfig(data []byte, format string) (*RootConfig, error) {
+	cfg, err := decodeRootConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Gateway != nil {
+		applyGatewayDefaults(cfg.Gateway)
+	}
+	if cfg.ConfigServer != nil {
+		applyConfigServerDefaults(cfg.ConfigServer)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadRootConfig reads a RootConfig from path, auto-detecting HJSON vs
+// YAML from the file extension.
+func LoadRootConfig(path string) (*RootConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRootConfig(data, configFormat(path))
+}
+
+// ReadRootConfigFromStdin reads a RootConfig from stdin as HJSON, for
+// -useconf.
+func ReadRootConfigFromStdin() (*RootConfig, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return parseRootConfig(data, "hjson")
+}
+
+// EncodeRootConfig renders cfg back to HJSON or YAML, used by -genconf and
+// -normaliseconf to round-trip a config file.
+func EncodeRootConfig(cfg *RootConfig, format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml":
+		return yaml.Marshal(cfg)
+	default:
+		return hjson.Marshal(cfg)
+	}
+}
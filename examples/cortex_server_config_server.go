@@ -7,26 +7,186 @@ package main
 */
 import "C"
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base32"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
 	"unsafe"
 )
 
 // ConfigServerService manages device connections and configurations
 type ConfigServerService struct {
-	dbURL     string
-	geoipPath string
-	protocol  string
-	port      uint16
+	dbURL             string
+	geoipPath         string
+	protocol          string
+	port              uint16
+	tlsCertFile       string
+	tlsKeyFile        string
+	requireClientCert bool
+
+	streamMu              sync.Mutex
+	subscribers           map[string][]*configSubscriber
+	resourceState         map[string]*networkResourceState
+	nackRollbackThreshold int
 }
 
 // DeviceInfo represents device information from the config server
 type DeviceInfo struct {
+	DeviceID  *string        `json:"device_id,omitempty"`
 	ClientURL *string        `json:"client_url"`
 	Info      *HeartbeatInfo `json:"info"`
 	Location  *Location      `json:"location"`
 }
 
+// DeviceID is a stable, human-verifiable device identifier derived from
+// the SHA-256 of a device's TLS client certificate DER bytes, in the
+// style of Syncthing's device IDs. It replaces trusting the opaque
+// machine_id/user_token pair reported in a device's heartbeat.
+type DeviceID string
+
+// GenerateDeviceIdentity loads the certificate/key pair at certPath and
+// keyPath, minting a self-signed ECDSA keypair and certificate there
+// first if either file is missing, and returns the DeviceID derived from
+// the certificate.
+func GenerateDeviceIdentity(certPath, keyPath string) (DeviceID, error) {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if os.IsNotExist(certErr) || os.IsNotExist(keyErr) {
+		if err := generateSelfSignedDeviceCert(certPath, keyPath); err != nil {
+			return "", fmt.Errorf("mint device certificate: %w", err)
+		}
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", fmt.Errorf("read device certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s does not contain a PEM certificate", certPath)
+	}
+
+	id := deviceIDFromCertDER(block.Bytes)
+	fmt.Printf("✓ device identity: %s\n", id)
+	return id, nil
+}
+
+// deviceIDFromCertDER derives a DeviceID from a certificate's DER bytes,
+// matching Syncthing's device ID scheme: SHA-256 the DER, base32-encode
+// the digest, insert a Luhn32 check digit after every 13 characters, then
+// split into hyphen-separated 7-character groups for easy transcription.
+func deviceIDFromCertDER(der []byte) DeviceID {
+	sum := sha256.Sum256(der)
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])
+	return DeviceID(chunkify(luhnify(encoded)))
+}
+
+// luhn32Alphabet is the RFC 4648 base32 alphabet, as indexed by luhn32Checkdigit.
+const luhn32Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// luhnify appends a Luhn mod-32 check digit after every 13 characters of
+// s, the same grouping Syncthing uses for its 52-character base32 digest.
+func luhnify(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		n := 13
+		if len(s) < n {
+			n = len(s)
+		}
+		chunk := s[:n]
+		s = s[n:]
+		b.WriteString(chunk)
+		b.WriteByte(luhn32Checkdigit(chunk))
+	}
+	return b.String()
+}
+
+// luhn32Checkdigit computes a Luhn mod-32 check digit over s.
+func luhn32Checkdigit(s string) byte {
+	factor, sum, n := 1, 0, len(luhn32Alphabet)
+	for i := 0; i < len(s); i++ {
+		codepoint := strings.IndexByte(luhn32Alphabet, s[i])
+		if codepoint < 0 {
+			continue
+		}
+		addend := factor * codepoint
+		addend = (addend / n) + (addend % n)
+		sum += addend
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+	remainder := sum % n
+	return luhn32Alphabet[(n-remainder)%n]
+}
+
+// chunkify splits s into hyphen-separated groups of 7 characters, for
+// readability when a DeviceID needs to be typed or read aloud.
+func chunkify(s string) string {
+	var groups []string
+	for len(s) > 0 {
+		n := 7
+		if len(s) < n {
+			n = len(s)
+		}
+		groups = append(groups, s[:n])
+		s = s[n:]
+	}
+	return strings.Join(groups, "-")
+}
+
+// generateSelfSignedDeviceCert mints an ECDSA keypair and a long-lived
+// self-signed client certificate, writing both to disk as PEM.
+func generateSelfSignedDeviceCert(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "cortex-bridge device"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600)
+}
+
 // HeartbeatInfo represents device heartbeat information
 type HeartbeatInfo struct {
 	MachineID               *string  `json:"machine_id"`
@@ -55,11 +215,24 @@ type NetworkConfig struct {
 // NewConfigServerService creates a new config server service
 func NewConfigServerService(dbURL, geoipPath, protocol string, port uint16) *ConfigServerService {
 	return &ConfigServerService{
-		dbURL:     dbURL,
-		geoipPath: geoipPath,
-		protocol:  protocol,
-		port:      port,
+		dbURL:                 dbURL,
+		geoipPath:             geoipPath,
+		protocol:              protocol,
+		port:                  port,
+		subscribers:           make(map[string][]*configSubscriber),
+		resourceState:         make(map[string]*networkResourceState),
+		nackRollbackThreshold: defaultNackRollbackThreshold,
+	}
+}
+
+// SetNackRollbackThreshold overrides how many consecutive NACKs for the
+// same resource version trigger an automatic rollback, in place of
+// defaultNackRollbackThreshold. n must be positive.
+func (c *ConfigServerService) SetNackRollbackThreshold(n int) {
+	if n <= 0 {
+		return
 	}
+	c.nackRollbackThreshold = n
 }
 
 // Initialize initializes the config server singleton
@@ -97,6 +270,16 @@ func (c *ConfigServerService) Initialize() error {
 	return nil
 }
 
+// EnableTLS configures the config server to require TLS with client
+// certificates, using certFile/keyFile as the server's own identity. Once
+// enabled, heartbeats whose derived DeviceID is not in the approved set
+// for the requesting org are rejected by the listener.
+func (c *ConfigServerService) EnableTLS(certFile, keyFile string) {
+	c.tlsCertFile = certFile
+	c.tlsKeyFile = keyFile
+	c.requireClientCert = true
+}
+
 // Start starts the config server listener
 func (c *ConfigServerService) Start() error {
 	var errMsg *C.char
@@ -109,11 +292,28 @@ func (c *ConfigServerService) Start() error {
 	cProtocol := C.CString(c.protocol)
 	defer C.free(unsafe.Pointer(cProtocol))
 
-	success := C.network_config_service_singleton_start(
-		cProtocol,
-		C.ushort(c.port),
-		&errMsg,
-	)
+	var success C.bool
+	if c.requireClientCert {
+		cCertFile := C.CString(c.tlsCertFile)
+		defer C.free(unsafe.Pointer(cCertFile))
+		cKeyFile := C.CString(c.tlsKeyFile)
+		defer C.free(unsafe.Pointer(cKeyFile))
+
+		success = C.network_config_service_singleton_start_tls(
+			cProtocol,
+			C.ushort(c.port),
+			cCertFile,
+			cKeyFile,
+			C.bool(true), // require and verify client certificates
+			&errMsg,
+		)
+	} else {
+		success = C.network_config_service_singleton_start(
+			cProtocol,
+			C.ushort(c.port),
+			&errMsg,
+		)
+	}
 
 	if !success {
 		if errMsg != nil {
@@ -122,10 +322,108 @@ func (c *ConfigServerService) Start() error {
 		return fmt.Errorf("failed to start config server")
 	}
 
-	fmt.Printf("✓ Config server listening on %s:%d\n", c.protocol, c.port)
+	if c.requireClientCert {
+		fmt.Printf("✓ Config server listening on %s:%d (TLS, client certs required)\n", c.protocol, c.port)
+	} else {
+		fmt.Printf("✓ Config server listening on %s:%d\n", c.protocol, c.port)
+	}
+	return nil
+}
+
+// ApproveDevice marks id as approved for orgID, letting it pass the TLS
+// client-certificate admission check on future heartbeats.
+func (c *ConfigServerService) ApproveDevice(orgID string, id DeviceID) error {
+	var errMsg *C.char
+	defer func() {
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cID := C.CString(string(id))
+	defer C.free(unsafe.Pointer(cID))
+
+	success := C.network_config_service_approve_device(cOrgID, cID, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return fmt.Errorf("failed to approve device %s: %s", id, C.GoString(errMsg))
+		}
+		return fmt.Errorf("failed to approve device %s", id)
+	}
+
+	fmt.Printf("✓ device %s approved for org %s\n", id, orgID)
+	return nil
+}
+
+// RevokeDevice removes id from the approved set for orgID; future
+// heartbeats from it are rejected at the TLS admission check.
+func (c *ConfigServerService) RevokeDevice(orgID string, id DeviceID) error {
+	var errMsg *C.char
+	defer func() {
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cID := C.CString(string(id))
+	defer C.free(unsafe.Pointer(cID))
+
+	success := C.network_config_service_revoke_device(cOrgID, cID, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return fmt.Errorf("failed to revoke device %s: %s", id, C.GoString(errMsg))
+		}
+		return fmt.Errorf("failed to revoke device %s", id)
+	}
+
+	fmt.Printf("✓ device %s revoked for org %s\n", id, orgID)
 	return nil
 }
 
+// PendingDevices lists devices that have connected and presented a client
+// certificate but have not yet been approved for orgID.
+func (c *ConfigServerService) PendingDevices(orgID string) ([]DeviceInfo, error) {
+	var resultJSON *C.char
+	var errMsg *C.char
+	defer func() {
+		if resultJSON != nil {
+			C.free_c_char(resultJSON)
+		}
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+
+	success := C.network_config_service_pending_devices(
+		cOrgID,
+		&resultJSON,
+		&errMsg,
+	)
+
+	if !success {
+		if errMsg != nil {
+			return nil, fmt.Errorf("failed to list pending devices: %s", C.GoString(errMsg))
+		}
+		return nil, fmt.Errorf("failed to list pending devices")
+	}
+
+	var result struct {
+		Devices []DeviceInfo `json:"devices"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(resultJSON)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse pending devices JSON: %w", err)
+	}
+
+	return result.Devices, nil
+}
+
 // ListDevices lists all devices for an organization
 func (c *ConfigServerService) ListDevices(orgID string) ([]DeviceInfo, error) {
 	var resultJSON *C.char
@@ -233,38 +531,35 @@ func (c *ConfigServerService) Destroy() error {
 	return nil
 }
 
-func main() {
-	// Example usage
-	dbURL := "root:password@tcp(localhost:3306)/cortex?parseTime=true&loc=UTC"
-	geoipPath := "./easytier_config_server/resources/geoip2-cn.mmdb"
-
-	configServer := NewConfigServerService(dbURL, geoipPath, "tcp", 11020)
-
-	// Initialize
-	if err := configServer.Initialize(); err != nil {
-		fmt.Printf("Error initializing config server: %v\n", err)
-		return
-	}
-
-	// Start listener
-	if err := configServer.Start(); err != nil {
-		fmt.Printf("Error starting config server: %v\n", err)
-		return
-	}
-
-	fmt.Println("Config server running...")
-	fmt.Println("Devices can now connect and will appear after sending heartbeat")
-
-	// In real usage:
-	// - Wait for devices to connect
-	// - Admin approves devices via API
-	// - Admin creates network configs
-	// - Config server sends configs to devices
-
-	// Example: List devices (after some connect)
-	// devices, _ := configServer.ListDevices("org-uuid-123")
-	// fmt.Printf("Connected devices: %v\n", devices)
-
-	// Keep running
-	// select {}
-}
+// This file has no main of its own: package main can only declare one, and
+// cortex_server_gateway.go's main is the actual process entrypoint — it
+// starts the gateway and, when a -conf file has a config_server section,
+// this service alongside it in the same process. To drive a
+// ConfigServerService by hand (e.g. from a test harness) outside that
+// entrypoint:
+//
+//	configServer := NewConfigServerService(dbURL, geoipPath, "tcp", 11020)
+//	configServer.EnableTLS("./certs/config-server.pem", "./certs/config-server-key.pem")
+//	if err := configServer.Initialize(); err != nil { ... }
+//	if err := configServer.Start(); err != nil { ... }
+//
+//	// List devices once some have connected:
+//	devices, _ := configServer.ListDevices("org-uuid-123")
+//
+//	// Watch for config pushes in-process (e.g. to drive an admin
+//	// dashboard) alongside the FFI listener's own push to connected devices:
+//	updates, cancel := configServer.WatchNetworkConfigs("org-uuid-123")
+//	defer cancel()
+//	go func() {
+//		for u := range updates {
+//			fmt.Printf("pushed %s to network %s\n", u.ResourceVersion, u.NetworkName)
+//		}
+//	}()
+//
+//	// Mint this process's own device identity, then approve a pending
+//	// device seen on the listener:
+//	id, _ := GenerateDeviceIdentity("./certs/admin.pem", "./certs/admin-key.pem")
+//	pending, _ := configServer.PendingDevices("org-uuid-123")
+//	for _, d := range pending {
+//		configServer.ApproveDevice("org-uuid-123", DeviceID(*d.DeviceID))
+//	}
@@ -7,7 +7,15 @@ package main
 */
 import "C"
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -36,12 +44,22 @@ type GatewayConfig struct {
 	RelayAllPeerRPC         bool
 	DisableUDPHolePunching  bool
 	PrivateMode             bool
+	NetstackMode            NetstackMode
 }
 
 // GatewayService manages the EasyTier gateway instance
 type GatewayService struct {
 	instanceName string
 	config       *GatewayConfig
+	reloadHook   func(ReloadResult)
+	netstack     Netstack
+}
+
+// Netstack returns the Go-side user-space network stack for this gateway
+// when NetstackMode is "gvisor", or nil for any other mode (those are
+// handled natively by the EasyTier core).
+func (g *GatewayService) Netstack() Netstack {
+	return g.netstack
 }
 
 // NewGatewayService creates a new gateway service
@@ -52,6 +70,307 @@ func NewGatewayService(config *GatewayConfig) *GatewayService {
 	}
 }
 
+// SetReloadHook registers a callback invoked after every Reload with a
+// summary of what changed, so admins can be notified without polling.
+func (g *GatewayService) SetReloadHook(hook func(ReloadResult)) {
+	g.reloadHook = hook
+}
+
+// ReloadResult describes the outcome of a live configuration reload.
+type ReloadResult struct {
+	InstanceName    string
+	LiveFields      []string
+	RestartFields   []string
+	RestartRequired bool
+}
+
+// reloadableFields are the GatewayConfig fields that can be pushed to a
+// running EasyTier core via targeted FFI calls without a restart.
+var reloadableFields = []string{
+	"PeerURLs",
+	"ListenerURLs",
+	"ForeignNetworkWhitelist",
+	"LatencyFirst",
+	"RelayAllPeerRPC",
+	"DisableUDPHolePunching",
+}
+
+// restartOnlyFields are GatewayConfig fields EasyTier only reads at core
+// start; changing one of these requires a full Stop/Start cycle.
+var restartOnlyFields = []string{
+	"InstanceName",
+	"NetworkName",
+	"NetworkSecret",
+	"DHCP",
+	"IPv4",
+	"IPv6",
+	"RPCPort",
+	"DefaultProtocol",
+	"DevName",
+	"EnableEncryption",
+	"EnableIPv6",
+	"MTU",
+	"EnableExitNode",
+	"NoTun",
+	"UseSmoltcp",
+	"DisableP2P",
+	"PrivateMode",
+	"NetstackMode",
+}
+
+// Reload diffs newConfig against the running configuration and pushes the
+// mutable subset (PeerURLs, ListenerURLs, ForeignNetworkWhitelist,
+// LatencyFirst, RelayAllPeerRPC, DisableUDPHolePunching) to the EasyTier
+// core via targeted FFI calls, without a Stop/Start cycle. If any field
+// that requires a restart has changed, Reload applies nothing and returns
+// an error naming those fields; the caller can then decide to Stop/Start
+// itself. Either way, the registered reload hook (if any) is invoked with
+// a ReloadResult describing what happened.
+func (g *GatewayService) Reload(newConfig *GatewayConfig) error {
+	old := g.config
+	result := ReloadResult{InstanceName: g.instanceName}
+
+	if newConfig.InstanceName != old.InstanceName {
+		result.RestartFields = append(result.RestartFields, "InstanceName")
+	}
+	if newConfig.NetworkName != old.NetworkName {
+		result.RestartFields = append(result.RestartFields, "NetworkName")
+	}
+	if newConfig.NetworkSecret != old.NetworkSecret {
+		result.RestartFields = append(result.RestartFields, "NetworkSecret")
+	}
+	if newConfig.DHCP != old.DHCP {
+		result.RestartFields = append(result.RestartFields, "DHCP")
+	}
+	if newConfig.IPv4 != old.IPv4 {
+		result.RestartFields = append(result.RestartFields, "IPv4")
+	}
+	if newConfig.IPv6 != old.IPv6 {
+		result.RestartFields = append(result.RestartFields, "IPv6")
+	}
+	if newConfig.RPCPort != old.RPCPort {
+		result.RestartFields = append(result.RestartFields, "RPCPort")
+	}
+	if newConfig.DefaultProtocol != old.DefaultProtocol {
+		result.RestartFields = append(result.RestartFields, "DefaultProtocol")
+	}
+	if newConfig.DevName != old.DevName {
+		result.RestartFields = append(result.RestartFields, "DevName")
+	}
+	if newConfig.EnableEncryption != old.EnableEncryption {
+		result.RestartFields = append(result.RestartFields, "EnableEncryption")
+	}
+	if newConfig.EnableIPv6 != old.EnableIPv6 {
+		result.RestartFields = append(result.RestartFields, "EnableIPv6")
+	}
+	if newConfig.MTU != old.MTU {
+		result.RestartFields = append(result.RestartFields, "MTU")
+	}
+	if newConfig.EnableExitNode != old.EnableExitNode {
+		result.RestartFields = append(result.RestartFields, "EnableExitNode")
+	}
+	if newConfig.NoTun != old.NoTun {
+		result.RestartFields = append(result.RestartFields, "NoTun")
+	}
+	if newConfig.UseSmoltcp != old.UseSmoltcp {
+		result.RestartFields = append(result.RestartFields, "UseSmoltcp")
+	}
+	if newConfig.DisableP2P != old.DisableP2P {
+		result.RestartFields = append(result.RestartFields, "DisableP2P")
+	}
+	if newConfig.PrivateMode != old.PrivateMode {
+		result.RestartFields = append(result.RestartFields, "PrivateMode")
+	}
+	if newConfig.NetstackMode != old.NetstackMode {
+		result.RestartFields = append(result.RestartFields, "NetstackMode")
+	}
+	result.RestartRequired = len(result.RestartFields) > 0
+
+	if !equalStringSlices(newConfig.PeerURLs, old.PeerURLs) {
+		result.LiveFields = append(result.LiveFields, "PeerURLs")
+	}
+	if !equalStringSlices(newConfig.ListenerURLs, old.ListenerURLs) {
+		result.LiveFields = append(result.LiveFields, "ListenerURLs")
+	}
+	if newConfig.ForeignNetworkWhitelist != old.ForeignNetworkWhitelist {
+		result.LiveFields = append(result.LiveFields, "ForeignNetworkWhitelist")
+	}
+	if newConfig.LatencyFirst != old.LatencyFirst {
+		result.LiveFields = append(result.LiveFields, "LatencyFirst")
+	}
+	if newConfig.RelayAllPeerRPC != old.RelayAllPeerRPC {
+		result.LiveFields = append(result.LiveFields, "RelayAllPeerRPC")
+	}
+	if newConfig.DisableUDPHolePunching != old.DisableUDPHolePunching {
+		result.LiveFields = append(result.LiveFields, "DisableUDPHolePunching")
+	}
+
+	defer func() {
+		if g.reloadHook != nil {
+			g.reloadHook(result)
+		}
+	}()
+
+	if result.RestartRequired {
+		return fmt.Errorf(
+			"gateway %q: fields require a restart and were not applied: %s",
+			g.instanceName, strings.Join(result.RestartFields, ", "),
+		)
+	}
+
+	for _, field := range result.LiveFields {
+		if err := g.pushLiveField(field, newConfig); err != nil {
+			return fmt.Errorf("gateway %q: failed to apply %s live: %w", g.instanceName, field, err)
+		}
+		// Record the field as applied to g.config immediately, rather than
+		// only after every field succeeds, so a later field's failure can't
+		// make g.config silently revert fields the core already accepted —
+		// the next Reload would otherwise re-diff against the stale value
+		// and re-push a field that was already live.
+		applyLiveFieldToConfig(field, g.config, newConfig)
+	}
+
+	return nil
+}
+
+// applyLiveFieldToConfig copies field's new value from src into dst. Used
+// by Reload to keep g.config in lockstep with what has actually been
+// pushed to the running core, field by field, instead of only at the end.
+func applyLiveFieldToConfig(field string, dst, src *GatewayConfig) {
+	switch field {
+	case "PeerURLs":
+		dst.PeerURLs = src.PeerURLs
+	case "ListenerURLs":
+		dst.ListenerURLs = src.ListenerURLs
+	case "ForeignNetworkWhitelist":
+		dst.ForeignNetworkWhitelist = src.ForeignNetworkWhitelist
+	case "LatencyFirst":
+		dst.LatencyFirst = src.LatencyFirst
+	case "RelayAllPeerRPC":
+		dst.RelayAllPeerRPC = src.RelayAllPeerRPC
+	case "DisableUDPHolePunching":
+		dst.DisableUDPHolePunching = src.DisableUDPHolePunching
+	}
+}
+
+// pushLiveField sends a single reloadable field to the running EasyTier
+// core through its targeted FFI setter.
+func (g *GatewayService) pushLiveField(field string, newConfig *GatewayConfig) error {
+	cName := C.CString(g.instanceName)
+	defer C.free(unsafe.Pointer(cName))
+
+	switch field {
+	case "PeerURLs":
+		cPeers := make([]*C.char, len(newConfig.PeerURLs))
+		for i, url := range newConfig.PeerURLs {
+			cPeers[i] = C.CString(url)
+			defer C.free(unsafe.Pointer(cPeers[i]))
+		}
+		var peersPtr **C.char
+		if len(cPeers) > 0 {
+			peersPtr = &cPeers[0]
+		}
+		if C.update_easytier_core_peer_urls(cName, peersPtr, C.int(len(cPeers))) != 0 {
+			return ffiError()
+		}
+	case "ListenerURLs":
+		cListeners := make([]*C.char, len(newConfig.ListenerURLs))
+		for i, url := range newConfig.ListenerURLs {
+			cListeners[i] = C.CString(url)
+			defer C.free(unsafe.Pointer(cListeners[i]))
+		}
+		var listenersPtr **C.char
+		if len(cListeners) > 0 {
+			listenersPtr = &cListeners[0]
+		}
+		if C.update_easytier_core_listener_urls(cName, listenersPtr, C.int(len(cListeners))) != 0 {
+			return ffiError()
+		}
+	case "ForeignNetworkWhitelist":
+		cWhitelist := C.CString(newConfig.ForeignNetworkWhitelist)
+		defer C.free(unsafe.Pointer(cWhitelist))
+		if C.update_easytier_core_foreign_network_whitelist(cName, cWhitelist) != 0 {
+			return ffiError()
+		}
+	case "LatencyFirst":
+		if C.update_easytier_core_latency_first(cName, boolToInt(newConfig.LatencyFirst)) != 0 {
+			return ffiError()
+		}
+	case "RelayAllPeerRPC":
+		if C.update_easytier_core_relay_all_peer_rpc(cName, boolToInt(newConfig.RelayAllPeerRPC)) != 0 {
+			return ffiError()
+		}
+	case "DisableUDPHolePunching":
+		if C.update_easytier_core_disable_udp_hole_punching(cName, boolToInt(newConfig.DisableUDPHolePunching)) != 0 {
+			return ffiError()
+		}
+	}
+	return nil
+}
+
+// Peers returns the peers the running EasyTier core is currently
+// connected to, for reporting in a GatewayStatus.
+func (g *GatewayService) Peers() ([]PeerInfo, error) {
+	cName := C.CString(g.instanceName)
+	defer C.free(unsafe.Pointer(cName))
+
+	var resultJSON *C.char
+	var errMsg *C.char
+	defer func() {
+		if resultJSON != nil {
+			C.free_c_char(resultJSON)
+		}
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	success := C.get_easytier_core_peer_info(cName, &resultJSON, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return nil, fmt.Errorf("failed to list peers: %s", C.GoString(errMsg))
+		}
+		return nil, fmt.Errorf("failed to list peers")
+	}
+
+	var result struct {
+		Peers []struct {
+			PeerURL   string `json:"peer_url"`
+			LatencyMs int64  `json:"latency_ms"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(resultJSON)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse peer info JSON: %w", err)
+	}
+
+	peers := make([]PeerInfo, len(result.Peers))
+	for i, p := range result.Peers {
+		peers[i] = PeerInfo{PeerURL: p.PeerURL, Latency: time.Duration(p.LatencyMs) * time.Millisecond}
+	}
+	return peers, nil
+}
+
+// ffiError reads the last error message set by the core FFI layer.
+func ffiError() error {
+	errMsg := C.easytier_common_get_error_msg()
+	if errMsg != nil {
+		return fmt.Errorf("%s", C.GoString(errMsg))
+	}
+	return fmt.Errorf("unknown error")
+}
+
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Start starts the gateway instance
 func (g *GatewayService) Start() error {
 	// Convert strings to C strings
@@ -109,8 +428,12 @@ func (g *GatewayService) Start() error {
 	cConfig.mtu = C.int(g.config.MTU)
 	cConfig.latency_first = boolToInt(g.config.LatencyFirst)
 	cConfig.enable_exit_node = boolToInt(g.config.EnableExitNode)
-	cConfig.no_tun = boolToInt(g.config.NoTun)
-	cConfig.use_smoltcp = boolToInt(g.config.UseSmoltcp)
+	// NetstackMode supersedes NoTun/UseSmoltcp but still drives the same
+	// two FFI booleans, since the core itself only knows about those.
+	noTun := g.config.NoTun || g.config.NetstackMode == NetstackGVisor || g.config.NetstackMode == NetstackNone
+	useSmoltcp := g.config.UseSmoltcp || g.config.NetstackMode == NetstackSmoltcp
+	cConfig.no_tun = boolToInt(noTun)
+	cConfig.use_smoltcp = boolToInt(useSmoltcp)
 	cConfig.foreign_network_whitelist = cForeignWhitelist
 	cConfig.disable_p2p = boolToInt(g.config.DisableP2P)
 	cConfig.relay_all_peer_rpc = boolToInt(g.config.RelayAllPeerRPC)
@@ -137,6 +460,33 @@ func (g *GatewayService) Start() error {
 		return fmt.Errorf("failed to start gateway (unknown error)")
 	}
 
+	if g.config.NetstackMode == NetstackGVisor {
+		packetCh := make(chan []byte, 256)
+		instanceName := g.config.InstanceName
+
+		ns, err := NewNetstack(NetstackGVisor, packetCh, func(pkt []byte) error {
+			return sendNetstackPacketToCore(instanceName, pkt)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start gVisor netstack: %w", err)
+		}
+
+		netstackChannelsMu.Lock()
+		netstackChannels[instanceName] = packetCh
+		netstackChannelsMu.Unlock()
+
+		// The core delivers inbound packets for this instance through the
+		// goNetstackDeliverPacket export, which looks the channel above up
+		// by instance name.
+		if C.easytier_core_bind_netstack_channel(cInstanceName) != 0 {
+			netstackChannelsMu.Lock()
+			delete(netstackChannels, instanceName)
+			netstackChannelsMu.Unlock()
+			return fmt.Errorf("failed to bind EasyTier core to gVisor netstack")
+		}
+		g.netstack = ns
+	}
+
 	fmt.Printf("✓ Gateway '%s' started successfully\n", g.config.InstanceName)
 	return nil
 }
@@ -151,10 +501,69 @@ func (g *GatewayService) Stop() error {
 		return fmt.Errorf("failed to stop gateway")
 	}
 
+	if g.netstack != nil {
+		netstackChannelsMu.Lock()
+		if ch, ok := netstackChannels[g.instanceName]; ok {
+			delete(netstackChannels, g.instanceName)
+			close(ch)
+		}
+		netstackChannelsMu.Unlock()
+		g.netstack = nil
+	}
+
 	fmt.Printf("✓ Gateway '%s' stopped\n", g.instanceName)
 	return nil
 }
 
+// netstackChannels maps an instance name to the channel its gVisor
+// netstack reads inbound packets from. goNetstackDeliverPacket uses it to
+// route packets the core hands back per instance.
+var (
+	netstackChannelsMu sync.Mutex
+	netstackChannels   = make(map[string]chan []byte)
+)
+
+// sendNetstackPacketToCore hands an outbound packet produced by the Go
+// gVisor stack back to the EasyTier core for instanceName, so it can be
+// routed onto the VPN the same way a kernel TUN write would be.
+func sendNetstackPacketToCore(instanceName string, pkt []byte) error {
+	if len(pkt) == 0 {
+		return nil
+	}
+
+	cInstanceName := C.CString(instanceName)
+	defer C.free(unsafe.Pointer(cInstanceName))
+
+	if C.easytier_core_send_netstack_packet(cInstanceName, (*C.uchar)(unsafe.Pointer(&pkt[0])), C.int(len(pkt))) != 0 {
+		return ffiError()
+	}
+	return nil
+}
+
+// goNetstackDeliverPacket is invoked by the EasyTier core, once per
+// inbound packet, for any instance bound via easytier_core_bind_netstack_channel.
+// It routes the packet to that instance's gVisor netstack via packetCh.
+//
+//export goNetstackDeliverPacket
+func goNetstackDeliverPacket(cInstanceName *C.char, data *C.uchar, length C.int) {
+	name := C.GoString(cInstanceName)
+
+	netstackChannelsMu.Lock()
+	ch, ok := netstackChannels[name]
+	netstackChannelsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	buf := C.GoBytes(unsafe.Pointer(data), length)
+	select {
+	case ch <- buf:
+	default:
+		// The core is delivering faster than the Go stack can drain;
+		// drop rather than block the FFI callback.
+	}
+}
+
 func boolToInt(b bool) C.int {
 	if b {
 		return 1
@@ -163,8 +572,132 @@ func boolToInt(b bool) C.int {
 }
 
 func main() {
-	// Example gateway configuration
-	config := &GatewayConfig{
+	confPath := flag.String("conf", "", "path to an HJSON or YAML config file (gateway + optional config_server)")
+	useConf := flag.Bool("useconf", false, "read the config from stdin instead of -conf")
+	genConf := flag.Bool("genconf", false, "print a config file populated with defaults, and exit")
+	normaliseConf := flag.Bool("normaliseconf", false, "round-trip -conf/-useconf through the parser and print the result")
+	flag.Parse()
+
+	if *genConf {
+		out, err := EncodeRootConfig(&RootConfig{
+			Gateway:      DefaultGatewayConfig(),
+			ConfigServer: DefaultConfigServerConfig(),
+		}, "hjson")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	var (
+		cfg *RootConfig
+		err error
+	)
+	switch {
+	case *useConf:
+		cfg, err = ReadRootConfigFromStdin()
+	case *confPath != "":
+		cfg, err = LoadRootConfig(*confPath)
+	default:
+		// No config file given: fall back to the bundled example config.
+		cfg = &RootConfig{Gateway: exampleGatewayConfig()}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *normaliseConf {
+		out, err := EncodeRootConfig(cfg, configFormat(*confPath))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	if cfg.Gateway == nil {
+		fmt.Println("Error: config has no gateway section")
+		os.Exit(1)
+	}
+
+	// GatewayManager supervises the instance (starting it, restarting it
+	// with backoff on crash) instead of main starting a single
+	// GatewayService itself; Add/Get below let additional instances be
+	// brought up the same way for e.g. one network per tenant.
+	manager := NewGatewayManager()
+	if err := manager.Add(cfg.Gateway); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	gateway, _ := manager.Get(cfg.Gateway.InstanceName)
+	gateway.SetReloadHook(notifyReload)
+	go logGatewayEvents(manager)
+
+	if cfg.ConfigServer != nil {
+		cs := NewConfigServerService(cfg.ConfigServer.DBURL, cfg.ConfigServer.GeoIPPath, cfg.ConfigServer.Protocol, cfg.ConfigServer.Port)
+		if cfg.ConfigServer.TLSCertFile != "" && cfg.ConfigServer.TLSKeyFile != "" {
+			cs.EnableTLS(cfg.ConfigServer.TLSCertFile, cfg.ConfigServer.TLSKeyFile)
+		}
+		cs.SetNackRollbackThreshold(cfg.ConfigServer.NackRollbackThreshold)
+		if err := cs.Initialize(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		} else if err := cs.Start(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+
+	// Reload the gateway from the same config file on SIGHUP instead of
+	// requiring a full Stop/Start cycle.
+	if *confPath != "" {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go watchForReload(gateway, *confPath, sighup)
+	}
+
+	fmt.Println("Gateway running under GatewayManager supervision... Press Ctrl+C to stop")
+
+	select {}
+}
+
+// logGatewayEvents prints each GatewayEvent the manager emits, so crashes
+// and restart-backoff decisions are visible without polling List.
+func logGatewayEvents(m *GatewayManager) {
+	for ev := range m.Events() {
+		if ev.Err != nil {
+			fmt.Printf("⚠ gateway %q -> %s: %v\n", ev.Name, ev.State, ev.Err)
+			continue
+		}
+		fmt.Printf("• gateway %q -> %s\n", ev.Name, ev.State)
+	}
+}
+
+// watchForReload reloads the gateway from configPath every time sighup
+// fires. It runs until the process exits.
+func watchForReload(gateway *GatewayService, configPath string, sighup <-chan os.Signal) {
+	for range sighup {
+		cfg, err := LoadRootConfig(configPath)
+		if err != nil {
+			fmt.Printf("✗ reload failed: could not read %s: %v\n", configPath, err)
+			continue
+		}
+		if cfg.Gateway == nil {
+			fmt.Printf("✗ reload failed: %s has no gateway section\n", configPath)
+			continue
+		}
+		if err := gateway.Reload(cfg.Gateway); err != nil {
+			fmt.Printf("✗ reload failed: %v\n", err)
+		}
+	}
+}
+
+// exampleGatewayConfig is the demo configuration used when the process is
+// started without -conf or -useconf.
+func exampleGatewayConfig() *GatewayConfig {
+	return &GatewayConfig{
 		InstanceName:  "cortex-server-gateway",
 		NetworkName:   "cortex-vpn",
 		NetworkSecret: "your-secret-key-here",
@@ -192,16 +725,18 @@ func main() {
 		DisableUDPHolePunching:  false,
 		PrivateMode:             true, // Server creates the network
 	}
+}
 
-	gateway := NewGatewayService(config)
-
-	if err := gateway.Start(); err != nil {
-		fmt.Printf("Error: %v\n", err)
+// notifyReload prints a summary of a Reload outcome; in production this
+// would also page/notify admins.
+func notifyReload(r ReloadResult) {
+	if r.RestartRequired {
+		fmt.Printf("⚠ reload for %q requires a restart: %s\n", r.InstanceName, strings.Join(r.RestartFields, ", "))
 		return
 	}
-
-	fmt.Println("Gateway running... Press Ctrl+C to stop")
-
-	// In real usage, wait for signal
-	// select {}
+	if len(r.LiveFields) == 0 {
+		fmt.Printf("• reload for %q: no changes\n", r.InstanceName)
+		return
+	}
+	fmt.Printf("✓ reload for %q applied live: %s\n", r.InstanceName, strings.Join(r.LiveFields, ", "))
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// gatewayBackoffMin and gatewayBackoffMax bound the exponential restart
+// backoff a managed instance uses after a crash: 100ms up to a 30s cap.
+const (
+	gatewayBackoffMin = 100 * time.Millisecond
+	gatewayBackoffMax = 30 * time.Second
+	// gatewayHealthyResetAfter is how long an instance must run without
+	// error before its restart count and backoff reset to the minimum.
+	gatewayHealthyResetAfter = 5 * time.Minute
+	// gatewayLivenessInterval is how often the supervisor checks an
+	// instance's EasyTier RPC port for liveness.
+	gatewayLivenessInterval = 5 * time.Second
+)
+
+// GatewayState is the lifecycle state of a managed gateway instance.
+type GatewayState string
+
+const (
+	GatewayStateStarting   GatewayState = "starting"
+	GatewayStateRunning    GatewayState = "running"
+	GatewayStateRestarting GatewayState = "restarting"
+	GatewayStateStopped    GatewayState = "stopped"
+	GatewayStateFailed     GatewayState = "failed"
+)
+
+// PeerInfo describes a peer the gateway is currently connected to.
+type PeerInfo struct {
+	PeerURL string
+	Latency time.Duration
+}
+
+// GatewayStatus is a point-in-time snapshot of a managed gateway instance.
+type GatewayStatus struct {
+	Name           string
+	State          GatewayState
+	Uptime         time.Duration
+	RestartCount   int
+	LastError      error
+	PeerCount      int
+	ConnectedPeers []PeerInfo
+}
+
+// GatewayEvent is emitted on a GatewayManager's event channel whenever a
+// managed instance changes state, for external observability.
+type GatewayEvent struct {
+	Name  string
+	State GatewayState
+	Err   error
+	Time  time.Time
+}
+
+// managedGateway is the supervisor's view of one instance: the service
+// itself plus the bookkeeping its supervisor goroutine needs.
+type managedGateway struct {
+	service *GatewayService
+	config  *GatewayConfig
+
+	mu           sync.Mutex
+	state        GatewayState
+	startedAt    time.Time
+	restartCount int
+	lastError    error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// GatewayManager owns a set of named GatewayService instances, each
+// supervised by its own goroutine that restarts it with exponential
+// backoff on crash, so a single Go process can host, monitor, and
+// gracefully drain multiple EasyTier cores (e.g. one per tenant network).
+type GatewayManager struct {
+	mu        sync.Mutex
+	instances map[string]*managedGateway
+	events    chan GatewayEvent
+}
+
+// NewGatewayManager returns an empty GatewayManager.
+func NewGatewayManager() *GatewayManager {
+	return &GatewayManager{
+		instances: make(map[string]*managedGateway),
+		events:    make(chan GatewayEvent, 64),
+	}
+}
+
+// Events returns the channel GatewayEvents are published on. The channel
+// is never closed; callers select on it for as long as they care to.
+func (m *GatewayManager) Events() <-chan GatewayEvent {
+	return m.events
+}
+
+// Add starts a new gateway instance under cfg.InstanceName and begins
+// supervising it. It returns an error if an instance with that name is
+// already managed.
+func (m *GatewayManager) Add(cfg *GatewayConfig) error {
+	m.mu.Lock()
+	if _, exists := m.instances[cfg.InstanceName]; exists {
+		m.mu.Unlock()
+		return fmt.Errorf("gateway %q is already managed", cfg.InstanceName)
+	}
+
+	mg := &managedGateway{
+		service: NewGatewayService(cfg),
+		config:  cfg,
+		state:   GatewayStateStarting,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	m.instances[cfg.InstanceName] = mg
+	m.mu.Unlock()
+
+	go m.supervise(mg)
+	return nil
+}
+
+// Remove gracefully drains and stops the named instance, then stops
+// supervising it.
+func (m *GatewayManager) Remove(name string) error {
+	m.mu.Lock()
+	mg, ok := m.instances[name]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("gateway %q is not managed", name)
+	}
+	delete(m.instances, name)
+	m.mu.Unlock()
+
+	close(mg.stop)
+	<-mg.done
+	return nil
+}
+
+// List returns a status snapshot for every managed instance.
+func (m *GatewayManager) List() []GatewayStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]GatewayStatus, 0, len(m.instances))
+	for name, mg := range m.instances {
+		mg.mu.Lock()
+		status := GatewayStatus{
+			Name:         name,
+			State:        mg.state,
+			RestartCount: mg.restartCount,
+			LastError:    mg.lastError,
+		}
+		running := mg.state == GatewayStateRunning && !mg.startedAt.IsZero()
+		if running {
+			status.Uptime = time.Since(mg.startedAt)
+		}
+		mg.mu.Unlock()
+
+		// Query peers outside mg.mu: it's an FFI round-trip to the core,
+		// and mg.service itself never changes after Add, so it's safe to
+		// read unlocked. Only ask a running instance — a starting/stopped
+		// one has no live core to answer.
+		if running {
+			if peers, err := mg.service.Peers(); err == nil {
+				status.PeerCount = len(peers)
+				status.ConnectedPeers = peers
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Get returns the named instance's underlying GatewayService, if managed.
+func (m *GatewayManager) Get(name string) (*GatewayService, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mg, ok := m.instances[name]
+	if !ok {
+		return nil, false
+	}
+	return mg.service, true
+}
+
+// supervise runs for the lifetime of one managed instance: start it,
+// poll its RPC port for liveness, and restart with exponential backoff
+// on crash, until Remove closes mg.stop.
+func (m *GatewayManager) supervise(mg *managedGateway) {
+	defer close(mg.done)
+
+	backoff := gatewayBackoffMin
+	for {
+		mg.mu.Lock()
+		mg.state = GatewayStateStarting
+		mg.mu.Unlock()
+		m.emit(mg, nil)
+
+		err := mg.service.Start()
+		if err != nil {
+			mg.mu.Lock()
+			mg.state = GatewayStateFailed
+			mg.startedAt = time.Time{}
+			mg.lastError = err
+			mg.mu.Unlock()
+			m.emit(mg, err)
+		} else {
+			mg.mu.Lock()
+			mg.state = GatewayStateRunning
+			mg.startedAt = time.Now()
+			mg.lastError = nil
+			mg.mu.Unlock()
+			m.emit(mg, nil)
+
+			err = m.watchLiveness(mg)
+			mg.mu.Lock()
+			mg.lastError = err
+			mg.mu.Unlock()
+		}
+
+		select {
+		case <-mg.stop:
+			mg.mu.Lock()
+			mg.state = GatewayStateStopped
+			mg.mu.Unlock()
+			_ = mg.service.Stop()
+			m.emit(mg, nil)
+			return
+		default:
+		}
+
+		// Only an instance that actually started and ran counts toward the
+		// healthy-reset window; mg.startedAt is the zero value here if
+		// Start itself failed, which would otherwise make a persistent
+		// can't-even-start crash loop look "healthy" on every iteration
+		// and keep backoff pinned near its minimum.
+		if !mg.startedAt.IsZero() && time.Since(mg.startedAt) >= gatewayHealthyResetAfter {
+			backoff = gatewayBackoffMin
+			mg.mu.Lock()
+			mg.restartCount = 0
+			mg.mu.Unlock()
+		}
+
+		mg.mu.Lock()
+		mg.state = GatewayStateRestarting
+		mg.restartCount++
+		mg.mu.Unlock()
+		m.emit(mg, err)
+
+		select {
+		case <-mg.stop:
+			mg.mu.Lock()
+			mg.state = GatewayStateStopped
+			mg.mu.Unlock()
+			m.emit(mg, nil)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > gatewayBackoffMax {
+			backoff = gatewayBackoffMax
+		}
+	}
+}
+
+// watchLiveness polls the instance's EasyTier RPC port until it stops
+// responding or mg.stop fires, then returns the error that ended the
+// poll (nil if mg.stop fired).
+func (m *GatewayManager) watchLiveness(mg *managedGateway) error {
+	ticker := time.NewTicker(gatewayLivenessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mg.stop:
+			return nil
+		case <-ticker.C:
+			if err := checkGatewayRPCAlive(mg.config.RPCPort); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkGatewayRPCAlive dials the gateway's RPC port to confirm the
+// EasyTier core is still responding.
+func checkGatewayRPCAlive(rpcPort int) error {
+	addr := fmt.Sprintf("127.0.0.1:%d", rpcPort)
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("rpc port %d unreachable: %w", rpcPort, err)
+	}
+	return conn.Close()
+}
+
+// emit publishes a GatewayEvent for mg's current state, dropping it if
+// the event channel is full rather than blocking the supervisor.
+func (m *GatewayManager) emit(mg *managedGateway, err error) {
+	mg.mu.Lock()
+	state := mg.state
+	mg.mu.Unlock()
+
+	select {
+	case m.events <- GatewayEvent{Name: mg.config.InstanceName, State: state, Err: err, Time: time.Now()}:
+	default:
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// NetstackMode selects how a GatewayService moves packets between the
+// EasyTier core and the Go process: through a kernel TUN device, the
+// core's own smoltcp stack, an in-process gVisor stack, or not at all.
+// It supersedes the older NoTun/UseSmoltcp booleans.
+type NetstackMode string
+
+const (
+	NetstackKernelTUN NetstackMode = "kernel-tun"
+	NetstackSmoltcp   NetstackMode = "smoltcp"
+	NetstackGVisor    NetstackMode = "gvisor"
+	NetstackNone      NetstackMode = "none"
+)
+
+// netstackIdleTimeout evicts endpoints that haven't moved data in this
+// long, so a headless deployment doesn't accumulate dead connections.
+const netstackIdleTimeout = 5 * time.Minute
+
+// Netstack lets Go code embedding the bridge originate and accept
+// connections inside the VPN without a kernel TUN device.
+type Netstack interface {
+	// Dial opens an outbound connection to addr over the VPN.
+	Dial(network, addr string) (net.Conn, error)
+	// Listen accepts inbound connections to addr arriving over the VPN.
+	Listen(network, addr string) (net.Listener, error)
+	// RegisterForwarder routes inbound connections on port to handler
+	// instead of requiring a prior Listen call.
+	RegisterForwarder(proto string, port uint16, handler func(net.Conn))
+}
+
+// NewNetstack returns a Netstack for mode. packetCh delivers raw IP
+// packets arriving from the EasyTier core; send is called with each
+// outbound packet the stack produces, so the caller can hand it back to
+// the core (e.g. over FFI) the same way a kernel TUN write would be.
+// kernel-tun, smoltcp, and none are handled natively by the EasyTier core
+// and have no Go-side stack, so NewNetstack returns (nil, nil) for them;
+// only gvisor is implemented here.
+func NewNetstack(mode NetstackMode, packetCh <-chan []byte, send func([]byte) error) (Netstack, error) {
+	switch mode {
+	case NetstackGVisor:
+		return newGVisorNetstack(packetCh, send)
+	case NetstackKernelTUN, NetstackSmoltcp, NetstackNone, "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown netstack mode %q", mode)
+	}
+}
+
+// gvisorNetstack is a user-space TCP/IP stack bound to the EasyTier
+// packet channel, analogous to how user-space WireGuard servers expose
+// an in-process TCP stack for headless deployments.
+type gvisorNetstack struct {
+	stack *stack.Stack
+	link  *channel.Endpoint
+	send  func([]byte) error
+
+	mu         sync.Mutex
+	nextConnID uint64
+	conns      map[uint64]net.Conn
+	lastUsed   map[uint64]time.Time
+}
+
+func newGVisorNetstack(packetCh <-chan []byte, send func([]byte) error) (*gvisorNetstack, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+
+	// CUBIC matches the congestion control EasyTier's own smoltcp path
+	// defaults to, so throughput behaves consistently across netstack modes.
+	if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &tcp.CongestionControlOption{CongestionControl: tcp.CUBIC}); err != nil {
+		return nil, fmt.Errorf("set congestion control: %v", err)
+	}
+
+	link := channel.New(512, header.IPv6MinimumMTU, "")
+	nicID := tcpip.NICID(1)
+	if err := s.CreateNIC(nicID, link); err != nil {
+		return nil, fmt.Errorf("create NIC: %v", err)
+	}
+	s.SetPromiscuousMode(nicID, true)
+	s.SetSpoofing(nicID, true)
+
+	n := &gvisorNetstack{
+		stack:    s,
+		link:     link,
+		send:     send,
+		conns:    make(map[uint64]net.Conn),
+		lastUsed: make(map[uint64]time.Time),
+	}
+	go n.pumpInbound(packetCh)
+	go n.pumpOutbound()
+	go n.evictIdleEndpoints()
+	return n, nil
+}
+
+// pumpInbound feeds raw IP packets arriving on the EasyTier packet
+// channel into the gVisor link endpoint, so the stack sees them as
+// arriving on its one NIC.
+func (n *gvisorNetstack) pumpInbound(packetCh <-chan []byte) {
+	for data := range packetCh {
+		if len(data) == 0 {
+			continue
+		}
+
+		proto := tcpip.NetworkProtocolNumber(ipv4.ProtocolNumber)
+		if header.IPVersion(data) == header.IPv6Version {
+			proto = ipv6.ProtocolNumber
+		}
+
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(data),
+		})
+		n.link.InjectInbound(proto, pkt)
+		pkt.DecRef()
+	}
+}
+
+// pumpOutbound drains packets the gVisor stack queues for transmission
+// on its one NIC and hands each one to n.send, so they reach the
+// EasyTier core the same way a kernel TUN read would.
+func (n *gvisorNetstack) pumpOutbound() {
+	for {
+		pkt := n.link.ReadContext(context.Background())
+		if pkt == nil {
+			return
+		}
+		data := pkt.ToView().AsSlice()
+		pkt.DecRef()
+
+		if n.send == nil {
+			continue
+		}
+		if err := n.send(data); err != nil {
+			fmt.Printf("✗ netstack: failed to send outbound packet: %v\n", err)
+		}
+	}
+}
+
+// evictIdleEndpoints periodically closes tracked connections that have not
+// moved data within netstackIdleTimeout, so a headless deployment doesn't
+// accumulate half-dead TCP endpoints the gVisor stack would otherwise hold
+// onto forever.
+func (n *gvisorNetstack) evictIdleEndpoints() {
+	ticker := time.NewTicker(netstackIdleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-netstackIdleTimeout)
+
+		n.mu.Lock()
+		var idle []net.Conn
+		for id, last := range n.lastUsed {
+			if last.Before(cutoff) {
+				idle = append(idle, n.conns[id])
+				delete(n.conns, id)
+				delete(n.lastUsed, id)
+			}
+		}
+		n.mu.Unlock()
+
+		for _, conn := range idle {
+			conn.Close()
+		}
+	}
+}
+
+// trackConn registers conn for idle eviction and wraps it so every Read/
+// Write refreshes its last-used time.
+func (n *gvisorNetstack) trackConn(conn net.Conn) net.Conn {
+	n.mu.Lock()
+	id := n.nextConnID
+	n.nextConnID++
+	n.conns[id] = conn
+	n.lastUsed[id] = time.Now()
+	n.mu.Unlock()
+
+	return &trackedConn{Conn: conn, n: n, id: id}
+}
+
+// untrack stops tracking id for idle eviction, e.g. because the caller
+// closed it itself.
+func (n *gvisorNetstack) untrack(id uint64) {
+	n.mu.Lock()
+	delete(n.conns, id)
+	delete(n.lastUsed, id)
+	n.mu.Unlock()
+}
+
+func (n *gvisorNetstack) touch(id uint64) {
+	n.mu.Lock()
+	if _, ok := n.conns[id]; ok {
+		n.lastUsed[id] = time.Now()
+	}
+	n.mu.Unlock()
+}
+
+// trackedConn wraps a net.Conn so traffic on it counts as activity for
+// evictIdleEndpoints, and closing it (by the caller or by eviction) stops
+// it being tracked.
+type trackedConn struct {
+	net.Conn
+	n  *gvisorNetstack
+	id uint64
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.n.touch(c.id)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.n.touch(c.id)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.n.untrack(c.id)
+	return c.Conn.Close()
+}
+
+// trackedListener wraps a net.Listener so every Accept'ed connection is
+// tracked for idle eviction, the same as a Dial'ed one.
+type trackedListener struct {
+	net.Listener
+	n *gvisorNetstack
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.n.trackConn(conn), nil
+}
+
+func (n *gvisorNetstack) Dial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("netstack dial: invalid address %q", addr)
+	}
+
+	var networkProto tcpip.NetworkProtocolNumber = ipv4.ProtocolNumber
+	if ip.To4() == nil {
+		networkProto = ipv6.ProtocolNumber
+	}
+
+	portNum, err := parsePort(port)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFromSlice(ip),
+		Port: portNum,
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		conn, err := gonet.DialTCP(n.stack, remote, networkProto)
+		if err != nil {
+			return nil, err
+		}
+		return n.trackConn(conn), nil
+	case "udp", "udp4", "udp6":
+		conn, err := gonet.DialUDP(n.stack, nil, &remote, networkProto)
+		if err != nil {
+			return nil, err
+		}
+		return n.trackConn(conn), nil
+	default:
+		return nil, fmt.Errorf("netstack dial: unsupported network %q", network)
+	}
+}
+
+func (n *gvisorNetstack) Listen(network, addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := parsePort(port)
+	if err != nil {
+		return nil, err
+	}
+
+	var local tcpip.FullAddress
+	if host != "" {
+		local.Addr = tcpip.AddrFromSlice(net.ParseIP(host))
+	}
+	local.Port = portNum
+
+	networkProto := tcpip.NetworkProtocolNumber(ipv4.ProtocolNumber)
+	if host != "" && net.ParseIP(host).To4() == nil {
+		networkProto = ipv6.ProtocolNumber
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		ln, err := gonet.ListenTCP(n.stack, local, networkProto)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedListener{Listener: ln, n: n}, nil
+	default:
+		return nil, fmt.Errorf("netstack listen: unsupported network %q", network)
+	}
+}
+
+func (n *gvisorNetstack) RegisterForwarder(proto string, port uint16, handler func(net.Conn)) {
+	go func() {
+		ln, err := n.Listen(proto, fmt.Sprintf(":%d", port))
+		if err != nil {
+			fmt.Printf("✗ netstack forwarder on %s/%d failed to listen: %v\n", proto, port, err)
+			return
+		}
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handler(conn)
+		}
+	}()
+}
+
+func parsePort(s string) (uint16, error) {
+	var port uint16
+	if _, err := fmt.Sscanf(s, "%d", &port); err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+/*
+#cgo LDFLAGS: -L../easytier_config_server/target/debug -leasytier_config_server
+#include "../easytier_config_server/include/easytier_config_server.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// defaultNackRollbackThreshold is how many consecutive NACKs for the same
+// resource version trigger an automatic rollback to the last
+// acknowledged-good version, used when a ConfigServerService isn't given
+// a different threshold via SetNackRollbackThreshold.
+const defaultNackRollbackThreshold = 3
+
+// ConfigUpdate is a single versioned push of a NetworkConfig to devices,
+// xDS-style: every update carries a ResourceVersion and a fresh Nonce.
+// AckConfigUpdate/NackConfigUpdate match a reply by ResourceVersion only;
+// the Nonce is not checked.
+type ConfigUpdate struct {
+	NetworkName     string        `json:"network_name"`
+	Config          NetworkConfig `json:"config"`
+	ResourceVersion string        `json:"resource_version"`
+	Nonce           string        `json:"nonce"`
+}
+
+// CancelFunc stops a WatchNetworkConfigs subscription.
+type CancelFunc func()
+
+type configSubscriber struct {
+	orgID string
+	ch    chan ConfigUpdate
+}
+
+// networkResourceState tracks a network's live config/version and the
+// last version a device actually ACKed, plus how many consecutive NACKs
+// the live version has received.
+type networkResourceState struct {
+	config          NetworkConfig
+	resourceVersion string
+
+	// ackedConfig/ackedVersion are only ever set by AckConfigUpdate, so a
+	// rollback always lands on a version a device has confirmed working
+	// rather than whatever merely preceded the bad push.
+	ackedConfig  *NetworkConfig
+	ackedVersion string
+
+	nackStreak int
+}
+
+// WatchNetworkConfigs subscribes to config updates for orgID. Whenever an
+// admin edits a NetworkConfig (peers, listeners, secret rotation) via
+// AddDeviceToNetwork, RemoveDeviceFromNetwork, or RotateNetworkSecret,
+// the full updated NetworkConfig is sent on the returned channel for
+// in-process observers; the org's connected devices receive the same
+// update over the FFI push channel (see publish). Call the returned
+// CancelFunc to stop the subscription.
+func (c *ConfigServerService) WatchNetworkConfigs(orgID string) (<-chan ConfigUpdate, CancelFunc) {
+	sub := &configSubscriber{orgID: orgID, ch: make(chan ConfigUpdate, 16)}
+
+	c.streamMu.Lock()
+	c.subscribers[orgID] = append(c.subscribers[orgID], sub)
+	c.streamMu.Unlock()
+
+	cancel := func() {
+		c.streamMu.Lock()
+		defer c.streamMu.Unlock()
+		subs := c.subscribers[orgID]
+		for i, s := range subs {
+			if s == sub {
+				c.subscribers[orgID] = append(subs[:i], subs[i+1:]...)
+				close(sub.ch)
+				break
+			}
+		}
+	}
+
+	return sub.ch, cancel
+}
+
+// publish records cfg as networkName's new live config, bumps its
+// resource version, fans the resulting ConfigUpdate out to every orgID
+// subscriber (for in-process observability, e.g. an admin dashboard),
+// and pushes it to the org's connected devices over the FFI push channel
+// the config server listener already holds open to them. A slow
+// subscriber's update is dropped rather than blocking the publish for
+// everyone else.
+func (c *ConfigServerService) publish(orgID, networkName string, cfg NetworkConfig) ConfigUpdate {
+	c.streamMu.Lock()
+
+	state, ok := c.resourceState[networkName]
+	if !ok {
+		state = &networkResourceState{}
+		c.resourceState[networkName] = state
+	}
+	state.config = cfg
+	state.resourceVersion = nextResourceVersion()
+	state.nackStreak = 0
+
+	update := ConfigUpdate{
+		NetworkName:     networkName,
+		Config:          cfg,
+		ResourceVersion: state.resourceVersion,
+		Nonce:           newNonce(),
+	}
+
+	for _, sub := range c.subscribers[orgID] {
+		select {
+		case sub.ch <- update:
+		default:
+		}
+	}
+
+	c.streamMu.Unlock()
+
+	if err := pushConfigUpdateToDevices(orgID, update); err != nil {
+		fmt.Printf("✗ failed to push config update for network %s to devices: %v\n", networkName, err)
+	}
+
+	return update
+}
+
+// AckConfigUpdate records that a device successfully applied
+// resourceVersion of networkName: it becomes the version rolled back to
+// on a future NACK streak, and the streak itself resets.
+func (c *ConfigServerService) AckConfigUpdate(networkName, resourceVersion string) {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+
+	state, ok := c.resourceState[networkName]
+	if !ok || state.resourceVersion != resourceVersion {
+		return
+	}
+	acked := state.config
+	state.ackedConfig = &acked
+	state.ackedVersion = resourceVersion
+	state.nackStreak = 0
+}
+
+// NackConfigUpdate records that a device failed to apply resourceVersion
+// of networkName. After c's configured NACK threshold is reached by
+// consecutive NACKs for the same version, the network is automatically
+// rolled back to its last ACKed version and republished.
+func (c *ConfigServerService) NackConfigUpdate(orgID, networkName, resourceVersion string) {
+	c.streamMu.Lock()
+	state, ok := c.resourceState[networkName]
+	if !ok || state.resourceVersion != resourceVersion {
+		c.streamMu.Unlock()
+		return
+	}
+	state.nackStreak++
+	shouldRollback := state.nackStreak >= c.nackRollbackThreshold &&
+		state.ackedConfig != nil &&
+		state.ackedVersion != state.resourceVersion
+	var goodConfig NetworkConfig
+	streak, ackedVersion := state.nackStreak, state.ackedVersion
+	if shouldRollback {
+		goodConfig = *state.ackedConfig
+	}
+	c.streamMu.Unlock()
+
+	if shouldRollback {
+		fmt.Printf("⚠ network %q: %d consecutive NACKs on %s, rolling back to %s\n", networkName, streak, resourceVersion, ackedVersion)
+		c.publish(orgID, networkName, goodConfig)
+	}
+}
+
+// pushConfigUpdateToDevices hands update to the FFI config server
+// listener, which fans it out over its existing connections to every
+// device in orgID subscribed to update.NetworkName — the actual
+// transport to connected devices, as opposed to the in-process Go
+// subscriber channels above.
+func pushConfigUpdateToDevices(orgID string, update ConfigUpdate) error {
+	updateJSON, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("marshal config update: %w", err)
+	}
+
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cUpdateJSON := C.CString(string(updateJSON))
+	defer C.free(unsafe.Pointer(cUpdateJSON))
+
+	var errMsg *C.char
+	defer func() {
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	if !C.network_config_service_push_config_update(cOrgID, cUpdateJSON, &errMsg) {
+		if errMsg != nil {
+			return fmt.Errorf("%s", C.GoString(errMsg))
+		}
+		return fmt.Errorf("unknown error")
+	}
+	return nil
+}
+
+// AddDeviceToNetwork adds deviceID to networkName's peer set and pushes
+// the resulting NetworkConfig to subscribed devices as a new resource
+// version.
+func (c *ConfigServerService) AddDeviceToNetwork(orgID, networkName, deviceID string) error {
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cNetworkName := C.CString(networkName)
+	defer C.free(unsafe.Pointer(cNetworkName))
+	cDeviceID := C.CString(deviceID)
+	defer C.free(unsafe.Pointer(cDeviceID))
+
+	var resultJSON *C.char
+	var errMsg *C.char
+	defer func() {
+		if resultJSON != nil {
+			C.free_c_char(resultJSON)
+		}
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	success := C.network_config_service_add_device_to_network(cOrgID, cNetworkName, cDeviceID, &resultJSON, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return fmt.Errorf("failed to add device %s to network %s: %s", deviceID, networkName, C.GoString(errMsg))
+		}
+		return fmt.Errorf("failed to add device %s to network %s", deviceID, networkName)
+	}
+
+	var cfg NetworkConfig
+	if err := json.Unmarshal([]byte(C.GoString(resultJSON)), &cfg); err != nil {
+		return fmt.Errorf("failed to parse updated network config: %w", err)
+	}
+
+	c.publish(orgID, networkName, cfg)
+	fmt.Printf("✓ device %s added to network %s\n", deviceID, networkName)
+	return nil
+}
+
+// RemoveDeviceFromNetwork removes deviceID from networkName's peer set
+// and pushes the resulting NetworkConfig to subscribed devices as a new
+// resource version.
+func (c *ConfigServerService) RemoveDeviceFromNetwork(orgID, networkName, deviceID string) error {
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cNetworkName := C.CString(networkName)
+	defer C.free(unsafe.Pointer(cNetworkName))
+	cDeviceID := C.CString(deviceID)
+	defer C.free(unsafe.Pointer(cDeviceID))
+
+	var resultJSON *C.char
+	var errMsg *C.char
+	defer func() {
+		if resultJSON != nil {
+			C.free_c_char(resultJSON)
+		}
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	success := C.network_config_service_remove_device_from_network(cOrgID, cNetworkName, cDeviceID, &resultJSON, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return fmt.Errorf("failed to remove device %s from network %s: %s", deviceID, networkName, C.GoString(errMsg))
+		}
+		return fmt.Errorf("failed to remove device %s from network %s", deviceID, networkName)
+	}
+
+	var cfg NetworkConfig
+	if err := json.Unmarshal([]byte(C.GoString(resultJSON)), &cfg); err != nil {
+		return fmt.Errorf("failed to parse updated network config: %w", err)
+	}
+
+	c.publish(orgID, networkName, cfg)
+	fmt.Printf("✓ device %s removed from network %s\n", deviceID, networkName)
+	return nil
+}
+
+// RotateNetworkSecret rotates networkName's secret and pushes the
+// resulting NetworkConfig to subscribed devices as a new resource
+// version.
+func (c *ConfigServerService) RotateNetworkSecret(orgID, networkName string) error {
+	cOrgID := C.CString(orgID)
+	defer C.free(unsafe.Pointer(cOrgID))
+	cNetworkName := C.CString(networkName)
+	defer C.free(unsafe.Pointer(cNetworkName))
+
+	var resultJSON *C.char
+	var errMsg *C.char
+	defer func() {
+		if resultJSON != nil {
+			C.free_c_char(resultJSON)
+		}
+		if errMsg != nil {
+			C.free_c_char(errMsg)
+		}
+	}()
+
+	success := C.network_config_service_rotate_network_secret(cOrgID, cNetworkName, &resultJSON, &errMsg)
+	if !success {
+		if errMsg != nil {
+			return fmt.Errorf("failed to rotate secret for network %s: %s", networkName, C.GoString(errMsg))
+		}
+		return fmt.Errorf("failed to rotate secret for network %s", networkName)
+	}
+
+	var cfg NetworkConfig
+	if err := json.Unmarshal([]byte(C.GoString(resultJSON)), &cfg); err != nil {
+		return fmt.Errorf("failed to parse updated network config: %w", err)
+	}
+
+	c.publish(orgID, networkName, cfg)
+	fmt.Printf("✓ secret rotated for network %s\n", networkName)
+	return nil
+}
+
+// nextResourceVersion mints a new, random xDS-style resource version.
+func nextResourceVersion() string {
+	return randomHex(8)
+}
+
+// newNonce mints a random per-update nonce.
+func newNonce() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}